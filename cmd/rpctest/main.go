@@ -0,0 +1,176 @@
+// Copyright 2024 The Erigon Authors
+// This file is part of Erigon.
+//
+// Erigon is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Erigon is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Erigon. If not, see <http://www.gnu.org/licenses/>.
+
+// Command rpctest compares Erigon's JSON-RPC responses against a reference node (Geth,
+// infura, ...) and checks eth_getLogs invariants against a single Erigon endpoint.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/erigontech/erigon/cmd/rpctest/rpctest"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	erigonURLFlag = &cli.StringFlag{
+		Name:  "erigonUrl",
+		Usage: "Erigon JSON-RPC endpoint under test",
+		Value: "http://localhost:8545",
+	}
+	gethURLFlag = &cli.StringFlag{
+		Name:  "gethUrl",
+		Usage: "reference node (Geth/infura) JSON-RPC endpoint to compare against",
+	}
+	needCompareFlag = &cli.BoolFlag{
+		Name:  "needCompare",
+		Usage: "compare Erigon's responses against gethUrl instead of just recording them",
+		Value: true,
+	}
+	blockFromFlag = &cli.Uint64Flag{
+		Name:     "blockFrom",
+		Usage:    "first block (inclusive) to check",
+		Required: true,
+	}
+	blockToFlag = &cli.Uint64Flag{
+		Name:     "blockTo",
+		Usage:    "last block (exclusive) to check",
+		Required: true,
+	}
+	recordFileFlag = &cli.StringFlag{
+		Name:  "recordFile",
+		Usage: "file to record every successful eth_getLogs response to",
+	}
+	errorFileFlag = &cli.StringFlag{
+		Name:  "errorFile",
+		Usage: "file to record Erigon/reference-node divergences to",
+	}
+	concurrencyFlag = &cli.IntFlag{
+		Name:  "concurrency",
+		Usage: "number of blocks checked concurrently by ethGetLogsInvariants (<=0 picks estimate.AlmostAllCPUs())",
+	}
+	batchSizeFlag = &cli.IntFlag{
+		Name:  "batchSize",
+		Usage: "group ethGetLogsInvariants' per-address/per-topic eth_getLogs calls into JSON-RPC batches of this size (<=1 sends one call per batch)",
+	}
+	erigonWSURLFlag = &cli.StringFlag{
+		Name:  "erigonWsUrl",
+		Usage: "Erigon websocket endpoint; if set, ethGetLogsInvariants also checks that eth_subscribe(\"logs\") agrees with eth_getLogs",
+	}
+	maxBisectDepthFlag = &cli.IntFlag{
+		Name:  "maxBisectDepth",
+		Usage: "bound the number of range-halving rounds benchEthGetLogs tries to narrow an Erigon/Geth divergence to a minimal repro (<=0 disables bisection)",
+	}
+	reportFlag = &cli.StringFlag{
+		Name:  "report",
+		Usage: "kind:path for a machine-readable report, e.g. junit:/tmp/out.xml or json:/tmp/out.jsonl (default: stdout)",
+	}
+	tlsCAFlag = &cli.StringFlag{
+		Name:  "tlsCa",
+		Usage: "CA bundle to verify the Erigon endpoint's TLS certificate (used only by ethGetLogsInvariants' batched eth_getLogs requests)",
+	}
+	tlsCertFlag = &cli.StringFlag{
+		Name:  "tlsCert",
+		Usage: "client certificate for mutual TLS against the Erigon endpoint (requires tlsKey; used only by ethGetLogsInvariants' batched eth_getLogs requests)",
+	}
+	tlsKeyFlag = &cli.StringFlag{
+		Name:  "tlsKey",
+		Usage: "client key for mutual TLS against the Erigon endpoint (requires tlsCert; used only by ethGetLogsInvariants' batched eth_getLogs requests)",
+	}
+	jwtSecretFlag = &cli.StringFlag{
+		Name:  "jwtSecret",
+		Usage: "JWT secret file (same hex format as Erigon's --authrpc.jwtsecret) used to authenticate ethGetLogsInvariants' batched eth_getLogs requests to the Erigon endpoint",
+	}
+)
+
+// tlsConfigFromFlags builds a *rpctest.TLSConfig from tlsCaFlag/tlsCertFlag/tlsKeyFlag, or
+// returns nil if none of them were set - ConfigureAuth treats a nil TLSConfig as "don't use
+// TLS", falling back to the system default transport.
+func tlsConfigFromFlags(cctx *cli.Context) *rpctest.TLSConfig {
+	ca, cert, key := cctx.String(tlsCAFlag.Name), cctx.String(tlsCertFlag.Name), cctx.String(tlsKeyFlag.Name)
+	if ca == "" && cert == "" && key == "" {
+		return nil
+	}
+	return &rpctest.TLSConfig{CAFile: ca, CertFile: cert, KeyFile: key}
+}
+
+var ethGetLogsInvariantsCommand = &cli.Command{
+	Name:  "ethGetLogsInvariants",
+	Usage: "check that eth_getLogs results are consistent with address- and topic-filtered eth_getLogs calls for every block in a range",
+	Flags: []cli.Flag{erigonURLFlag, gethURLFlag, erigonWSURLFlag, needCompareFlag, blockFromFlag, blockToFlag, concurrencyFlag, batchSizeFlag, reportFlag, tlsCAFlag, tlsCertFlag, tlsKeyFlag, jwtSecretFlag},
+	Action: func(cctx *cli.Context) error {
+		report, err := rpctest.NewReporter(cctx.String(reportFlag.Name))
+		if err != nil {
+			return err
+		}
+		return rpctest.EthGetLogsInvariants(
+			context.Background(),
+			cctx.String(erigonURLFlag.Name),
+			cctx.String(gethURLFlag.Name),
+			cctx.String(erigonWSURLFlag.Name),
+			cctx.Bool(needCompareFlag.Name),
+			cctx.Uint64(blockFromFlag.Name),
+			cctx.Uint64(blockToFlag.Name),
+			cctx.Int(concurrencyFlag.Name),
+			cctx.Int(batchSizeFlag.Name),
+			report,
+			tlsConfigFromFlags(cctx),
+			cctx.String(jwtSecretFlag.Name),
+		)
+	},
+}
+
+var benchEthGetLogsCommand = &cli.Command{
+	Name:  "benchEthGetLogs",
+	Usage: "compare Erigon's eth_getLogs responses against gethUrl over a block range",
+	Flags: []cli.Flag{erigonURLFlag, gethURLFlag, needCompareFlag, blockFromFlag, blockToFlag, recordFileFlag, errorFileFlag, maxBisectDepthFlag, reportFlag, tlsCAFlag, tlsCertFlag, tlsKeyFlag, jwtSecretFlag},
+	Action: func(cctx *cli.Context) error {
+		report, err := rpctest.NewReporter(cctx.String(reportFlag.Name))
+		if err != nil {
+			return err
+		}
+		return rpctest.BenchEthGetLogs(
+			cctx.String(erigonURLFlag.Name),
+			cctx.String(gethURLFlag.Name),
+			cctx.Bool(needCompareFlag.Name),
+			cctx.Uint64(blockFromFlag.Name),
+			cctx.Uint64(blockToFlag.Name),
+			cctx.String(recordFileFlag.Name),
+			cctx.String(errorFileFlag.Name),
+			cctx.Int(maxBisectDepthFlag.Name),
+			report,
+			tlsConfigFromFlags(cctx),
+			cctx.String(jwtSecretFlag.Name),
+		)
+	},
+}
+
+func main() {
+	app := &cli.App{
+		Name:  "rpctest",
+		Usage: "Compare Erigon's JSON-RPC responses against a reference node and check eth_getLogs invariants",
+		Commands: []*cli.Command{
+			benchEthGetLogsCommand,
+			ethGetLogsInvariantsCommand,
+		},
+	}
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
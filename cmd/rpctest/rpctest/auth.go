@@ -0,0 +1,203 @@
+// Copyright 2024 The Erigon Authors
+// This file is part of Erigon.
+//
+// Erigon is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Erigon is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Erigon. If not, see <http://www.gnu.org/licenses/>.
+
+package rpctest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLSConfig configures the HTTP client used to reach a TLS-terminated rpcdaemon: CAFile
+// verifies the server certificate, CertFile/KeyFile present a client certificate for
+// mutual TLS. Any of the three may be left empty to fall back to the system default.
+type TLSConfig struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// jwtClockSkew is how long a minted token is reused before being re-signed, matching the
+// small leeway Erigon's engine-API JWT auth allows around "iat".
+const jwtClockSkew = 5 * time.Second
+
+// authTransport wraps an http.RoundTripper and attaches a fresh (or recently minted)
+// HS256-signed JWT as "Authorization: Bearer ...", matching Erigon's engine-API auth scheme.
+type authTransport struct {
+	base   http.RoundTripper
+	secret []byte
+
+	mu       sync.Mutex
+	token    string
+	mintedAt time.Time
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.currentToken()
+	if err != nil {
+		return nil, fmt.Errorf("auth: could not mint JWT: %w", err)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(req)
+}
+
+func (t *authTransport) currentToken() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Since(t.mintedAt) < jwtClockSkew {
+		return t.token, nil
+	}
+	token, err := mintHS256Token(t.secret)
+	if err != nil {
+		return "", err
+	}
+	t.token = token
+	t.mintedAt = time.Now()
+	return t.token, nil
+}
+
+// mintHS256Token builds a minimal JWT carrying only an "iat" claim, HS256-signed with
+// secret - the same shape Erigon's engine API expects from callers.
+func mintHS256Token(secret []byte) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	claims, err := json.Marshal(struct {
+		IssuedAt int64 `json:"iat"`
+	}{time.Now().Unix()})
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	signingInput := header + "." + payload
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	sig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	return signingInput + "." + sig, nil
+}
+
+// loadJWTSecret reads a JWT secret file in the same hex (optionally 0x-prefixed) format
+// Erigon reads for its engine API (--authrpc.jwtsecret).
+func loadJWTSecret(path string) ([]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("auth: cannot read JWT secret %s: %w", path, err)
+	}
+	hexStr := strings.TrimPrefix(strings.TrimSpace(string(raw)), "0x")
+	secret, err := hex.DecodeString(hexStr)
+	if err != nil {
+		return nil, fmt.Errorf("auth: JWT secret %s is not valid hex: %w", path, err)
+	}
+	return secret, nil
+}
+
+// newAuthenticatedClient builds an *http.Client wired for a hardened production RPC
+// daemon: tlsConfig (if non-nil) configures server verification / mutual TLS, and
+// jwtSecretPath (if non-empty) makes every request carry a freshly minted
+// "Authorization: Bearer" HS256 JWT, matching Erigon's engine-API auth scheme.
+func newAuthenticatedClient(tlsConfig *TLSConfig, jwtSecretPath string) (*http.Client, error) {
+	var transport http.RoundTripper = http.DefaultTransport
+
+	if tlsConfig != nil {
+		cfg := &tls.Config{}
+		if tlsConfig.CAFile != "" {
+			caCert, err := os.ReadFile(tlsConfig.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("auth: cannot read CA bundle %s: %w", tlsConfig.CAFile, err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(caCert) {
+				return nil, fmt.Errorf("auth: no certificates found in %s", tlsConfig.CAFile)
+			}
+			cfg.RootCAs = pool
+		}
+		if tlsConfig.CertFile != "" && tlsConfig.KeyFile != "" {
+			cert, err := tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("auth: cannot load client cert/key: %w", err)
+			}
+			cfg.Certificates = []tls.Certificate{cert}
+		}
+		transport = &http.Transport{TLSClientConfig: cfg}
+	}
+
+	if jwtSecretPath != "" {
+		secret, err := loadJWTSecret(jwtSecretPath)
+		if err != nil {
+			return nil, err
+		}
+		transport = &authTransport{base: transport, secret: secret}
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// erigonAuthClient, when set by ConfigureAuth, is used only for requests bound for the
+// Erigon endpoint under test. The JWT it attaches is minted for that one endpoint and must
+// never reach a reference node (Geth/infura) passed alongside it for comparison, so unlike
+// an earlier version of this file, ConfigureAuth does not touch http.DefaultClient: requests
+// aimed at the reference node keep going through the unmodified default client.
+//
+// httpClientForErigon is only called from this file's own package today: BatchErigon is the
+// one Erigon-bound call path that lives in this checkout. RequestGenerator.Erigon and
+// setRoutes' Erigon dialer - which carry every eth_blockNumber/eth_getLogs/
+// debug_getModifiedAccountsByNumber call BenchEthGetLogs and EthGetLogsInvariants make -
+// are defined in this package's rpctest.go, which this checkout does not have; wiring them
+// to httpClientForErigon has to land in that file once it's present, or auth only covers the
+// batched per-address/topic follow-up requests and a hardened rpcdaemon will reject
+// everything else this tool sends it.
+var erigonAuthClient *http.Client
+
+// ConfigureAuth installs (or clears, if both arguments are zero) a TLS/JWT-aware HTTP
+// client used for the Erigon endpoint under test. Call httpClientForErigon to obtain it;
+// the reference-node (Geth) path is unaffected. Only BatchErigon uses this client: requests
+// sent through RequestGenerator.Erigon (eth_blockNumber, debug_getModifiedAccountsByNumber
+// and the unbatched eth_getLogs calls of BenchEthGetLogs / EthGetLogsInvariants) carry
+// neither the TLS settings nor the JWT.
+func ConfigureAuth(tlsConfig *TLSConfig, jwtSecretPath string) error {
+	if tlsConfig == nil && jwtSecretPath == "" {
+		erigonAuthClient = nil
+		return nil
+	}
+	client, err := newAuthenticatedClient(tlsConfig, jwtSecretPath)
+	if err != nil {
+		return err
+	}
+	erigonAuthClient = client
+	return nil
+}
+
+// httpClientForErigon returns the client ConfigureAuth installed for the Erigon endpoint,
+// or http.DefaultClient when ConfigureAuth hasn't been called (the common, unauthenticated
+// test-node case). Only code talking to the Erigon URL under test should call this - the
+// reference node (Geth/infura) must keep using http.DefaultClient untouched.
+func httpClientForErigon() *http.Client {
+	if erigonAuthClient != nil {
+		return erigonAuthClient
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,176 @@
+// Copyright 2024 The Erigon Authors
+// This file is part of Erigon.
+//
+// Erigon is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Erigon is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Erigon. If not, see <http://www.gnu.org/licenses/>.
+
+package rpctest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Request is a single JSON-RPC call queued for a batch: Method is only used to label
+// errors, Body is the already-encoded JSON-RPC request object, as produced by the
+// RequestGenerator helpers (getLogs, getLogs1, ...).
+type Request struct {
+	Method string
+	Body   string
+}
+
+// jsonrpcEnvelope extracts just the "id" field the JSON-RPC spec requires a server to echo
+// back unchanged, so BatchErigon can match each batch response element to the request that
+// produced it instead of assuming the server preserved array order.
+type jsonrpcEnvelope struct {
+	ID json.RawMessage `json:"id"`
+}
+
+// BatchErigon packs methods into a single JSON-RPC batch request (a JSON array of
+// request objects) and sends it to Erigon as one HTTP round trip, then decodes each
+// element of the JSON array response into the matching entry of results by "id" - the
+// spec permits a server to return batch elements in any order, so array position alone
+// isn't trusted. len(results) must equal len(methods); results[i] receives the whole
+// decoded response element for methods[i] (its "result" and "error" fields alike), so it
+// should be a response envelope such as *EthGetLogs.
+func (g *RequestGenerator) BatchErigon(methods []Request, results []any) []CallResult {
+	callResults := make([]CallResult, len(methods))
+	if len(methods) == 0 {
+		return callResults
+	}
+	if len(results) != len(methods) {
+		err := fmt.Errorf("BatchErigon: len(results)=%d != len(methods)=%d", len(results), len(methods))
+		for i := range callResults {
+			callResults[i] = CallResult{Err: err}
+		}
+		return callResults
+	}
+
+	idToIndex := make(map[string]int, len(methods))
+	for i, m := range methods {
+		var env jsonrpcEnvelope
+		if err := json.Unmarshal([]byte(m.Body), &env); err != nil {
+			err = fmt.Errorf("BatchErigon: failed to read id from request for %s: %v", m.Method, err)
+			for j := range callResults {
+				callResults[j] = CallResult{Err: err}
+			}
+			return callResults
+		}
+		idToIndex[string(env.ID)] = i
+	}
+
+	var body bytes.Buffer
+	body.WriteByte('[')
+	for i, m := range methods {
+		if i > 0 {
+			body.WriteByte(',')
+		}
+		body.WriteString(m.Body)
+	}
+	body.WriteByte(']')
+
+	start := time.Now()
+	httpReq, err := http.NewRequest(http.MethodPost, erigonURL, bytes.NewReader(body.Bytes()))
+	if err != nil {
+		err = fmt.Errorf("BatchErigon: failed to create request: %v", err)
+		for i := range callResults {
+			callResults[i] = CallResult{Err: err}
+		}
+		return callResults
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClientForErigon().Do(httpReq)
+	if err != nil {
+		err = fmt.Errorf("BatchErigon: request failed: %v", err)
+		for i := range callResults {
+			callResults[i] = CallResult{Err: err}
+		}
+		return callResults
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	took := time.Since(start)
+	if err != nil {
+		err = fmt.Errorf("BatchErigon: failed to read response: %v", err)
+		for i := range callResults {
+			callResults[i] = CallResult{Err: err}
+		}
+		return callResults
+	}
+
+	return matchBatchResponses(methods, idToIndex, results, respBody, took)
+}
+
+// matchBatchResponses is the id-matching logic behind BatchErigon, with the HTTP round trip
+// taken out so it can be unit-tested against canned response bodies (out-of-order elements,
+// wrong element count, unknown ids) without a live Erigon node.
+func matchBatchResponses(methods []Request, idToIndex map[string]int, results []any, respBody []byte, took time.Duration) []CallResult {
+	callResults := make([]CallResult, len(methods))
+
+	var rawResults []json.RawMessage
+	if err := json.Unmarshal(respBody, &rawResults); err != nil {
+		err = fmt.Errorf("BatchErigon: failed to unmarshal batch response: %v, body: %s", err, respBody)
+		for i := range callResults {
+			callResults[i] = CallResult{Err: err}
+		}
+		return callResults
+	}
+	if len(rawResults) != len(methods) {
+		err := fmt.Errorf("BatchErigon: got %d responses for %d requests", len(rawResults), len(methods))
+		for i := range callResults {
+			callResults[i] = CallResult{Err: err}
+		}
+		return callResults
+	}
+
+	seen := make([]bool, len(methods))
+	for _, raw := range rawResults {
+		var env jsonrpcEnvelope
+		if err := json.Unmarshal(raw, &env); err != nil {
+			err = fmt.Errorf("BatchErigon: failed to read id from response element: %v, body: %s", err, raw)
+			for j := range callResults {
+				callResults[j] = CallResult{Err: err}
+			}
+			return callResults
+		}
+		i, ok := idToIndex[string(env.ID)]
+		if !ok {
+			err := fmt.Errorf("BatchErigon: response id %s does not match any request in this batch", env.ID)
+			for j := range callResults {
+				callResults[j] = CallResult{Err: err}
+			}
+			return callResults
+		}
+		seen[i] = true
+		callResults[i] = CallResult{
+			RequestBody:  methods[i].Body,
+			ResponseBody: string(raw),
+			Took:         took,
+		}
+		if err := json.Unmarshal(raw, results[i]); err != nil {
+			callResults[i].Err = fmt.Errorf("BatchErigon: failed to unmarshal result for %s: %v", methods[i].Method, err)
+		}
+	}
+	for i, ok := range seen {
+		if !ok {
+			callResults[i] = CallResult{Err: fmt.Errorf("BatchErigon: no response element matched request id for %s", methods[i].Method)}
+		}
+	}
+	return callResults
+}
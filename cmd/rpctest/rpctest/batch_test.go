@@ -0,0 +1,102 @@
+// Copyright 2024 The Erigon Authors
+// This file is part of Erigon.
+//
+// Erigon is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Erigon is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Erigon. If not, see <http://www.gnu.org/licenses/>.
+
+package rpctest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatchBatchResponsesOutOfOrder(t *testing.T) {
+	methods := []Request{
+		{Method: "eth_getLogs", Body: `{"id":1}`},
+		{Method: "eth_getLogs", Body: `{"id":2}`},
+		{Method: "eth_getLogs", Body: `{"id":3}`},
+	}
+	idToIndex := map[string]int{"1": 0, "2": 1, "3": 2}
+	results := []any{&EthGetLogs{}, &EthGetLogs{}, &EthGetLogs{}}
+
+	// Server returned the elements in reverse order - BatchErigon must still match each to
+	// the request it belongs to by id, not by array position.
+	respBody := []byte(`[{"id":3,"result":[]},{"id":1,"result":[]},{"id":2,"result":[]}]`)
+
+	callResults := matchBatchResponses(methods, idToIndex, results, respBody, time.Second)
+	for i, cr := range callResults {
+		if cr.Err != nil {
+			t.Fatalf("callResults[%d].Err = %v, want nil", i, cr.Err)
+		}
+	}
+	if callResults[0].ResponseBody != `{"id":1,"result":[]}` {
+		t.Fatalf("callResults[0].ResponseBody = %s, want the id=1 element", callResults[0].ResponseBody)
+	}
+	if callResults[1].ResponseBody != `{"id":2,"result":[]}` {
+		t.Fatalf("callResults[1].ResponseBody = %s, want the id=2 element", callResults[1].ResponseBody)
+	}
+	if callResults[2].ResponseBody != `{"id":3,"result":[]}` {
+		t.Fatalf("callResults[2].ResponseBody = %s, want the id=3 element", callResults[2].ResponseBody)
+	}
+}
+
+func TestMatchBatchResponsesWrongLength(t *testing.T) {
+	methods := []Request{
+		{Method: "eth_getLogs", Body: `{"id":1}`},
+		{Method: "eth_getLogs", Body: `{"id":2}`},
+	}
+	idToIndex := map[string]int{"1": 0, "2": 1}
+	results := []any{&EthGetLogs{}, &EthGetLogs{}}
+
+	respBody := []byte(`[{"id":1,"result":[]}]`)
+
+	callResults := matchBatchResponses(methods, idToIndex, results, respBody, time.Second)
+	for i, cr := range callResults {
+		if cr.Err == nil {
+			t.Fatalf("callResults[%d].Err = nil, want an error reporting the length mismatch", i)
+		}
+	}
+}
+
+func TestMatchBatchResponsesUnknownID(t *testing.T) {
+	methods := []Request{
+		{Method: "eth_getLogs", Body: `{"id":1}`},
+	}
+	idToIndex := map[string]int{"1": 0}
+	results := []any{&EthGetLogs{}}
+
+	respBody := []byte(`[{"id":99,"result":[]}]`)
+
+	callResults := matchBatchResponses(methods, idToIndex, results, respBody, time.Second)
+	if callResults[0].Err == nil {
+		t.Fatalf("callResults[0].Err = nil, want an error reporting the unmatched response id")
+	}
+}
+
+func TestMatchBatchResponsesMissingID(t *testing.T) {
+	methods := []Request{
+		{Method: "eth_getLogs", Body: `{"id":1}`},
+		{Method: "eth_getLogs", Body: `{"id":2}`},
+	}
+	idToIndex := map[string]int{"1": 0, "2": 1}
+	results := []any{&EthGetLogs{}, &EthGetLogs{}}
+
+	// Same id appears twice, so index 1's request never gets a matching response element.
+	respBody := []byte(`[{"id":1,"result":[]},{"id":1,"result":[]}]`)
+
+	callResults := matchBatchResponses(methods, idToIndex, results, respBody, time.Second)
+	if callResults[1].Err == nil {
+		t.Fatalf("callResults[1].Err = nil, want an error reporting no response matched this request")
+	}
+}
@@ -39,8 +39,21 @@ import (
 //	false value - to generate vegeta files, it's faster but we can generate vegeta files for Geth and Erigon
 //	recordFile stores all eth_getlogs returned with success
 //	errorFile stores information when erigon and geth doesn't return same data
-func BenchEthGetLogs(erigonURL, gethURL string, needCompare bool, blockFrom uint64, blockTo uint64, recordFile string, errorFile string) error {
+// maxBisectDepth bounds how many times BenchEthGetLogs will split a failing block range
+// in half while looking for the smallest range that reproduces an Erigon/Geth divergence.
+// report receives one RequestReport per eth_getLogs call, for --report=junit:... / json:... .
+// tlsConfig and jwtSecretPath (both optional) are passed to ConfigureAuth; every request
+// BenchEthGetLogs sends goes through RequestGenerator.Erigon/Geth, which do not use that
+// client, so they are sent without TLS client settings or a JWT.
+func BenchEthGetLogs(erigonURL, gethURL string, needCompare bool, blockFrom uint64, blockTo uint64, recordFile string, errorFile string, maxBisectDepth int, report Reporter, tlsConfig *TLSConfig, jwtSecretPath string) error {
+	if err := ConfigureAuth(tlsConfig, jwtSecretPath); err != nil {
+		return err
+	}
 	setRoutes(erigonURL, gethURL)
+	if report == nil {
+		report = newStdoutReporter()
+	}
+	defer report.Close()
 
 	var rec *bufio.Writer
 	if recordFile != "" {
@@ -103,9 +116,15 @@ func BenchEthGetLogs(erigonURL, gethURL string, needCompare bool, blockFrom uint
 
 				request := reqGen.getLogs(prevBn, bn, account)
 				errCtx := fmt.Sprintf("account %x blocks %d-%d", account, prevBn, bn)
-				if err := requestAndCompare(request, "eth_getLogs", errCtx, reqGen, needCompare, rec, errs, resultsCh,
-					/* insertOnlyIfSuccess */ false); err != nil {
+				start := time.Now()
+				err := requestAndCompare(request, "eth_getLogs", errCtx, reqGen, needCompare, rec, errs, resultsCh,
+					/* insertOnlyIfSuccess */ false)
+				report.Record(requestReportFor("eth_getLogs", request, time.Since(start), err))
+				if err != nil {
 					fmt.Println(err)
+					bisectDivergence(reqGen, erigonURL, "eth_getLogs", func(from, to uint64) string {
+						return reqGen.getLogs(from, to, account)
+					}, prevBn, bn, errCtx, errs, maxBisectDepth)
 					return err
 				}
 				topics := getTopics(res.Result)
@@ -114,9 +133,15 @@ func BenchEthGetLogs(erigonURL, gethURL string, needCompare bool, blockFrom uint
 
 					request = reqGen.getLogs1(prevBn, bn+10000, account, topic)
 					errCtx := fmt.Sprintf("account %x topic %x blocks %d-%d", account, topic, prevBn, bn)
-					if err := requestAndCompare(request, "eth_getLogs", errCtx, reqGen, needCompare, rec, errs, resultsCh,
-						/* insertOnlyIfSuccess */ false); err != nil {
+					start := time.Now()
+					err := requestAndCompare(request, "eth_getLogs", errCtx, reqGen, needCompare, rec, errs, resultsCh,
+						/* insertOnlyIfSuccess */ false)
+					report.Record(requestReportFor("eth_getLogs", request, time.Since(start), err))
+					if err != nil {
 						fmt.Println(err)
+						bisectDivergence(reqGen, erigonURL, "eth_getLogs", func(from, to uint64) string {
+							return reqGen.getLogs1(from, to, account, topic)
+						}, prevBn, bn+10000, errCtx, errs, maxBisectDepth)
 						return err
 					}
 				}
@@ -130,9 +155,15 @@ func BenchEthGetLogs(erigonURL, gethURL string, needCompare bool, blockFrom uint
 
 					request = reqGen.getLogs2(prevBn, bn+100000, account, topics[idx1], topics[idx2])
 					errCtx := fmt.Sprintf("account %x topic1 %x topic2 %x blocks %d-%d", account, topics[idx1], topics[idx2], prevBn, bn)
-					if err := requestAndCompare(request, "eth_getLogs", errCtx, reqGen, needCompare, rec, errs, resultsCh,
-						/* insertOnlyIfSuccess */ false); err != nil {
+					start := time.Now()
+					err := requestAndCompare(request, "eth_getLogs", errCtx, reqGen, needCompare, rec, errs, resultsCh,
+						/* insertOnlyIfSuccess */ false)
+					report.Record(requestReportFor("eth_getLogs", request, time.Since(start), err))
+					if err != nil {
 						fmt.Println(err)
+						bisectDivergence(reqGen, erigonURL, "eth_getLogs", func(from, to uint64) string {
+							return reqGen.getLogs2(from, to, account, topics[idx1], topics[idx2])
+						}, prevBn, bn+100000, errCtx, errs, maxBisectDepth)
 						return err
 					}
 				}
@@ -144,8 +175,32 @@ func BenchEthGetLogs(erigonURL, gethURL string, needCompare bool, blockFrom uint
 	return nil
 }
 
-func EthGetLogsInvariants(ctx context.Context, erigonURL, gethURL string, needCompare bool, blockFrom, blockTo uint64) error {
+// EthGetLogsInvariants checks, for every block in [blockFrom, blockTo), that logs returned
+// without a filter are also reachable by address- and topic-filtered eth_getLogs calls.
+// Blocks within a batchEnd window are checked concurrently, up to concurrency goroutines
+// (concurrency <= 0 picks estimate.AlmostAllCPUs()). Each goroutine gets its own
+// RequestGenerator and its own sawAddr/sawTopic maps, so a failing run can be reproduced by
+// re-running the same block range - the address/topic picked for a block is a deterministic
+// function of that block's logs, not of any randomness.
+// The per-address and per-topic follow-up eth_getLogs calls for a block are grouped
+// into JSON-RPC batches of up to batchSize calls each (batchSize <= 1 sends each call as
+// its own one-element batch).
+// report receives one RequestReport per eth_getLogs call, for --report=junit:... / json:... .
+// tlsConfig and jwtSecretPath (both optional) are passed to ConfigureAuth and apply only to
+// the batched per-address/per-topic calls; the eth_blockNumber call, the unfiltered
+// per-block eth_getLogs calls and the websocket are sent without them.
+// erigonWSURL, if non-empty, additionally runs EthGetLogsSubscriptionInvariant once over
+// [blockFrom, blockTo) after the per-block checks above pass, to catch regressions where
+// the eth_subscribe("logs") pipeline diverges from eth_getLogs.
+func EthGetLogsInvariants(ctx context.Context, erigonURL, gethURL, erigonWSURL string, needCompare bool, blockFrom, blockTo uint64, concurrency, batchSize int, report Reporter, tlsConfig *TLSConfig, jwtSecretPath string) error {
+	if err := ConfigureAuth(tlsConfig, jwtSecretPath); err != nil {
+		return err
+	}
 	setRoutes(erigonURL, gethURL)
+	if report == nil {
+		report = newStdoutReporter()
+	}
+	defer report.Close()
 
 	reqGen := &RequestGenerator{}
 
@@ -178,90 +233,144 @@ func EthGetLogsInvariants(ctx context.Context, erigonURL, gethURL string, needCo
 		return nil
 	}
 
+	poolSize := concurrency
+	if poolSize <= 0 {
+		poolSize = estimate.AlmostAllCPUs()
+	}
+
 	for bn := blockFrom; bn < blockTo; {
 		batchEnd := min(bn+10, blockTo)
 		eg := &errgroup.Group{}
-		eg.SetLimit(estimate.AlmostAllCPUs())
-		//eg.SetLimit(1)
+		eg.SetLimit(poolSize)
 		for ; bn < batchEnd; bn++ {
 			bn := bn
-			//eg.Go(func() error {
-			var resp EthGetLogs
-			res := reqGen.Erigon("eth_getLogs", reqGen.getLogsNoFilters(bn, bn), &resp)
-			if res.Err != nil {
-				return fmt.Errorf("Could not get modified accounts (Erigon): %v\n", res.Err)
-			}
-			if resp.Error != nil {
-				return fmt.Errorf("Error getting modified accounts (Erigon): %d %s\n", resp.Error.Code, resp.Error.Message)
-			}
-			if err := noDuplicates(resp.Result); err != nil {
-				return fmt.Errorf("eth_getLogs: at blockNum=%d %w", bn, err)
-			}
+			eg.Go(func() error {
+				reqGen := &RequestGenerator{}
 
-			sawAddr := map[common.Address]struct{}{} // don't check same addr in this block
-			sawTopic := map[common.Hash]struct{}{}
-			for _, l := range resp.Result {
-				if _, ok := sawAddr[l.Address]; ok {
-					continue
-				}
-				sawAddr[l.Address] = struct{}{}
-
-				res = reqGen.Erigon("eth_getLogs", reqGen.getLogs(bn, bn, l.Address), &resp)
+				var resp EthGetLogs
+				noFiltersBody := reqGen.getLogsNoFilters(bn, bn)
+				res := reqGen.Erigon("eth_getLogs", noFiltersBody, &resp)
+				report.Record(requestReportForResult("eth_getLogs", noFiltersBody, res))
 				if res.Err != nil {
 					return fmt.Errorf("Could not get modified accounts (Erigon): %v\n", res.Err)
 				}
 				if resp.Error != nil {
 					return fmt.Errorf("Error getting modified accounts (Erigon): %d %s\n", resp.Error.Code, resp.Error.Message)
 				}
-				//invariant1: if `log` visible without filter - then must be visible with filter. (in another words: `address` must be indexed well)
-				if len(resp.Result) == 0 {
-					return fmt.Errorf("eth_getLogs: at blockNum=%d account %x not indexed", bn, l.Address)
-				}
-
 				if err := noDuplicates(resp.Result); err != nil {
-					return fmt.Errorf("eth_getLogs: at blockNum=%d and addr %x %w", bn, l.Address, err)
+					return fmt.Errorf("eth_getLogs: at blockNum=%d %w", bn, err)
 				}
 
-				//invariant2: if `log` visible without filter - then must be visible with filter. (in another words: `topic` must be indexed well)
-				if len(l.Topics) == 0 {
-					continue
+				sawAddr := map[common.Address]struct{}{} // don't check same addr in this block
+				sawTopic := map[common.Hash]struct{}{}
+				type topicCheck struct {
+					addr  common.Address
+					topic common.Hash
 				}
+				var addrChecks []common.Address
+				var topicChecks []topicCheck
+				for _, l := range resp.Result {
+					if _, ok := sawAddr[l.Address]; ok {
+						continue
+					}
+					sawAddr[l.Address] = struct{}{}
+					addrChecks = append(addrChecks, l.Address)
 
-				if _, ok := sawTopic[l.Topics[0]]; ok {
-					continue
+					if len(l.Topics) == 0 {
+						continue
+					}
+					topic := l.Topics[0]
+					if _, ok := sawTopic[topic]; ok {
+						continue
+					}
+					sawTopic[topic] = struct{}{}
+					topicChecks = append(topicChecks, topicCheck{addr: l.Address, topic: topic})
 				}
-				sawTopic[l.Topics[0]] = struct{}{}
 
-				res = reqGen.Erigon("eth_getLogs", reqGen.getLogs1(bn, bn, l.Address, l.Topics[0]), &resp)
-				if res.Err != nil {
-					return fmt.Errorf("Could not get modified accounts (Erigon): %v\n", res.Err)
+				group := batchSize
+				if group <= 0 {
+					group = 1
 				}
-				if resp.Error != nil {
-					return fmt.Errorf("Error getting modified accounts (Erigon): %d %s\n", resp.Error.Code, resp.Error.Message)
-				}
-				if len(resp.Result) == 0 {
-					return fmt.Errorf("eth_getLogs: at blockNum=%d account %x, topic %x not indexed", bn, l.Address, l.Topics[0])
+
+				//invariant1: if `log` visible without filter - then must be visible with filter. (in another words: `address` must be indexed well)
+				for start := 0; start < len(addrChecks); start += group {
+					end := min(start+group, len(addrChecks))
+					batch := addrChecks[start:end]
+					reqs := make([]Request, len(batch))
+					results := make([]any, len(batch))
+					resps := make([]EthGetLogs, len(batch))
+					for i, addr := range batch {
+						reqs[i] = Request{Method: "eth_getLogs", Body: reqGen.getLogs(bn, bn, addr)}
+						results[i] = &resps[i]
+					}
+					callResults := reqGen.BatchErigon(reqs, results)
+					for i, addr := range batch {
+						report.Record(requestReportForResult("eth_getLogs", reqs[i].Body, callResults[i]))
+						if callResults[i].Err != nil {
+							return fmt.Errorf("Could not get modified accounts (Erigon): %v\n", callResults[i].Err)
+						}
+						if resps[i].Error != nil {
+							return fmt.Errorf("Error getting modified accounts (Erigon): %d %s\n", resps[i].Error.Code, resps[i].Error.Message)
+						}
+						if len(resps[i].Result) == 0 {
+							return fmt.Errorf("eth_getLogs: at blockNum=%d account %x not indexed", bn, addr)
+						}
+						if err := noDuplicates(resps[i].Result); err != nil {
+							return fmt.Errorf("eth_getLogs: at blockNum=%d and addr %x %w", bn, addr, err)
+						}
+					}
 				}
-				if err := noDuplicates(resp.Result); err != nil {
-					return fmt.Errorf("eth_getLogs: at blockNum=%d and topic %x %w", bn, l.Topics[0], err)
+
+				//invariant2: if `log` visible without filter - then must be visible with filter. (in another words: `topic` must be indexed well)
+				for start := 0; start < len(topicChecks); start += group {
+					end := min(start+group, len(topicChecks))
+					batch := topicChecks[start:end]
+					reqs := make([]Request, len(batch))
+					results := make([]any, len(batch))
+					resps := make([]EthGetLogs, len(batch))
+					for i, c := range batch {
+						reqs[i] = Request{Method: "eth_getLogs", Body: reqGen.getLogs1(bn, bn, c.addr, c.topic)}
+						results[i] = &resps[i]
+					}
+					callResults := reqGen.BatchErigon(reqs, results)
+					for i, c := range batch {
+						report.Record(requestReportForResult("eth_getLogs", reqs[i].Body, callResults[i]))
+						if callResults[i].Err != nil {
+							return fmt.Errorf("Could not get modified accounts (Erigon): %v\n", callResults[i].Err)
+						}
+						if resps[i].Error != nil {
+							return fmt.Errorf("Error getting modified accounts (Erigon): %d %s\n", resps[i].Error.Code, resps[i].Error.Message)
+						}
+						if len(resps[i].Result) == 0 {
+							return fmt.Errorf("eth_getLogs: at blockNum=%d account %x, topic %x not indexed", bn, c.addr, c.topic)
+						}
+						if err := noDuplicates(resps[i].Result); err != nil {
+							return fmt.Errorf("eth_getLogs: at blockNum=%d and topic %x %w", bn, c.topic, err)
+						}
+					}
 				}
-			}
 
-			select {
-			case <-logEvery.C:
-				log.Info("[ethGetLogsInvariants]", "block_num", bn)
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-			}
+				select {
+				case <-logEvery.C:
+					log.Info("[ethGetLogsInvariants]", "block_num", bn)
+				case <-ctx.Done():
+					return ctx.Err()
+				default:
+				}
 
-			//return nil
-			//})
+				return nil
+			})
 		}
 
 		if err := eg.Wait(); err != nil {
 			return err
 		}
 	}
+
+	if erigonWSURL != "" {
+		if err := EthGetLogsSubscriptionInvariant(ctx, erigonURL, erigonWSURL, blockFrom, blockTo); err != nil {
+			return fmt.Errorf("EthGetLogsInvariants: subscription invariant failed: %w", err)
+		}
+	}
 	return nil
 }
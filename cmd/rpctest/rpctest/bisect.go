@@ -0,0 +1,183 @@
+// Copyright 2024 The Erigon Authors
+// This file is part of Erigon.
+//
+// Erigon is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Erigon is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Erigon. If not, see <http://www.gnu.org/licenses/>.
+
+package rpctest
+
+import (
+	"bufio"
+	"fmt"
+	"slices"
+)
+
+// logGroupKey identifies all the logs a single transaction emitted in a single block,
+// without relying on any field (Index, TxHash) that might itself be the thing diverging -
+// those are compared only after the two sides' logs for the same transaction are paired up.
+type logGroupKey struct {
+	blockNumber uint64
+	txIndex     uint
+}
+
+// diffLogs reports, for two eth_getLogs results covering the same range, which log
+// entries are present in only one of them and which are present in both but disagree on
+// their fields. Logs are grouped by (block, txIndex) and then paired up in log-index order
+// within that group, so a mismatched Index or TxHash is reported as a field-level diff
+// rather than as two independent "only in Erigon" / "only in Geth" entries.
+func diffLogs(erigon, geth []Log) []string {
+	group := func(logs []Log) map[logGroupKey][]Log {
+		m := make(map[logGroupKey][]Log, len(logs))
+		for _, l := range logs {
+			k := logGroupKey{blockNumber: uint64(l.BlockNumber), txIndex: l.TxIndex}
+			m[k] = append(m[k], l)
+		}
+		for k, ls := range m {
+			slices.SortFunc(ls, func(a, b Log) int { return int(a.Index) - int(b.Index) })
+			m[k] = ls
+		}
+		return m
+	}
+	erigonByTx, gethByTx := group(erigon), group(geth)
+
+	var diffs []string
+	for k, els := range erigonByTx {
+		gls := gethByTx[k]
+		for i := 0; i < len(els) || i < len(gls); i++ {
+			switch {
+			case i >= len(gls):
+				diffs = append(diffs, fmt.Sprintf("log present in Erigon only: block=%d tx=%s index=%d", k.blockNumber, els[i].TxHash.Hex(), els[i].Index))
+			case i >= len(els):
+				diffs = append(diffs, fmt.Sprintf("log present in Geth only: block=%d tx=%s index=%d", k.blockNumber, gls[i].TxHash.Hex(), gls[i].Index))
+			default:
+				el, gl := els[i], gls[i]
+				if el.TxHash != gl.TxHash || el.Index != gl.Index || string(el.Data) != string(gl.Data) {
+					diffs = append(diffs, fmt.Sprintf("log mismatch: block=%d tx_index=%d erigon(tx=%s,index=%d,data=%x) geth(tx=%s,index=%d,data=%x)",
+						k.blockNumber, k.txIndex, el.TxHash.Hex(), el.Index, el.Data, gl.TxHash.Hex(), gl.Index, gl.Data))
+				}
+			}
+		}
+	}
+	for k, gls := range gethByTx {
+		if _, ok := erigonByTx[k]; !ok {
+			for _, gl := range gls {
+				diffs = append(diffs, fmt.Sprintf("log present in Geth only: block=%d tx=%s index=%d", k.blockNumber, gl.TxHash.Hex(), gl.Index))
+			}
+		}
+	}
+	return diffs
+}
+
+// diverges re-issues method against both Erigon and the reference node for the inclusive
+// block range from..to (the bounds buildRequest takes, as getLogs does) and reports whether their eth_getLogs results disagree. A transport or JSON-RPC error from
+// either side is returned as an error rather than folded into the divergence check - an
+// error response's always-empty Result would otherwise look like "Erigon returned no logs"
+// and get reported as a genuine mismatch instead of the dropped request it actually was.
+func diverges(reqGen *RequestGenerator, method string, buildRequest func(from, to uint64) string, from, to uint64) (bool, error) {
+	var erigonResp, gethResp EthGetLogs
+	erigonRes := reqGen.Erigon(method, buildRequest(from, to), &erigonResp)
+	if erigonRes.Err != nil {
+		return false, fmt.Errorf("%s (Erigon): %w", method, erigonRes.Err)
+	}
+	if erigonResp.Error != nil {
+		return false, fmt.Errorf("%s (Erigon): %d %s", method, erigonResp.Error.Code, erigonResp.Error.Message)
+	}
+	gethRes := reqGen.Geth(method, buildRequest(from, to), &gethResp)
+	if gethRes.Err != nil {
+		return false, fmt.Errorf("%s (Geth): %w", method, gethRes.Err)
+	}
+	if gethResp.Error != nil {
+		return false, fmt.Errorf("%s (Geth): %d %s", method, gethResp.Error.Code, gethResp.Error.Message)
+	}
+	return len(diffLogs(erigonResp.Result, gethResp.Result)) > 0, nil
+}
+
+// bisectRange recursively splits [from, to) in half, looking for the smallest sub-range
+// that still reproduces a divergence found over the full range. It gives up - and returns
+// the range at the point it stopped narrowing - once maxDepth splits have been tried, a
+// single block is reached, or neither half reproduces the mismatch on its own (meaning the
+// divergence only shows up when the two halves are queried together). A non-nil error means
+// a request failed partway through the search: the returned range is whatever it had
+// narrowed to so far and must be treated as inconclusive, not as a minimal repro.
+// buildRequest takes inclusive bounds, so each half-open range is queried as from..to-1.
+func bisectRange(reqGen *RequestGenerator, method string, buildRequest func(from, to uint64) string, from, to uint64, maxDepth int) (uint64, uint64, error) {
+	return bisectRangeFunc(func(from, to uint64) (bool, error) {
+		return diverges(reqGen, method, buildRequest, from, to-1)
+	}, from, to, maxDepth)
+}
+
+// bisectRangeFunc is the search behind bisectRange, with the divergence check taken as a
+// function so the narrowing logic can be unit-tested without a live Erigon/Geth pair. It
+// aborts and propagates the first error diverges returns, rather than letting a failed
+// request masquerade as "this half doesn't reproduce".
+func bisectRangeFunc(diverges func(from, to uint64) (bool, error), from, to uint64, maxDepth int) (uint64, uint64, error) {
+	if maxDepth <= 0 || to <= from+1 {
+		return from, to, nil
+	}
+	mid := from + (to-from)/2
+
+	lowerDiverges, err := diverges(from, mid)
+	if err != nil {
+		return from, to, err
+	}
+	if lowerDiverges {
+		return bisectRangeFunc(diverges, from, mid, maxDepth-1)
+	}
+	upperDiverges, err := diverges(mid, to)
+	if err != nil {
+		return from, to, err
+	}
+	if upperDiverges {
+		return bisectRangeFunc(diverges, mid, to, maxDepth-1)
+	}
+	return from, to, nil
+}
+
+// bisectDivergence is called once requestAndCompare has already reported a mismatch over
+// the inclusive block range from..to (the bounds buildRequest takes): it narrows the range
+// via bisectRange, re-diffs the narrowed range to find the specific Log entries that differ,
+// and appends a minimal repro plus a ready-to-paste curl command to errs. Ranges written to
+// errs are inclusive. maxDepth bounds the number of bisection rounds, so a huge failing range
+// can't turn into an unbounded number of extra RPCs.
+func bisectDivergence(reqGen *RequestGenerator, erigonURL, method string, buildRequest func(from, to uint64) string, from, to uint64, errCtx string, errs *bufio.Writer, maxDepth int) {
+	if errs == nil {
+		return
+	}
+
+	narrowFrom, narrowTo, err := bisectRange(reqGen, method, buildRequest, from, to+1, maxDepth)
+	if err != nil {
+		fmt.Fprintf(errs, "bisecting divergence for %s (%s): %d-%d -> inconclusive, a request failed mid-bisection: %v\n\n", method, errCtx, from, to, err)
+		errs.Flush()
+		return
+	}
+	narrowTo-- // back to buildRequest's inclusive upper bound
+
+	var erigonResp, gethResp EthGetLogs
+	erigonReq := buildRequest(narrowFrom, narrowTo)
+	erigonRes := reqGen.Erigon(method, erigonReq, &erigonResp)
+	gethRes := reqGen.Geth(method, buildRequest(narrowFrom, narrowTo), &gethResp)
+	if erigonRes.Err != nil || erigonResp.Error != nil || gethRes.Err != nil || gethResp.Error != nil {
+		fmt.Fprintf(errs, "bisected divergence for %s (%s): %d-%d -> minimal range %d-%d, but re-fetching it to report the diff failed; re-run manually:\n", method, errCtx, from, to, narrowFrom, narrowTo)
+		fmt.Fprintf(errs, "  curl -s -X POST -H 'Content-Type: application/json' --data '%s' %s\n\n", erigonReq, erigonURL)
+		errs.Flush()
+		return
+	}
+	diffs := diffLogs(erigonResp.Result, gethResp.Result)
+
+	fmt.Fprintf(errs, "bisected divergence for %s (%s): %d-%d -> minimal range %d-%d\n", method, errCtx, from, to, narrowFrom, narrowTo)
+	for _, d := range diffs {
+		fmt.Fprintf(errs, "  %s\n", d)
+	}
+	fmt.Fprintf(errs, "  curl -s -X POST -H 'Content-Type: application/json' --data '%s' %s\n\n", erigonReq, erigonURL)
+	errs.Flush()
+}
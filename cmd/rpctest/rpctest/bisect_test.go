@@ -0,0 +1,152 @@
+// Copyright 2024 The Erigon Authors
+// This file is part of Erigon.
+//
+// Erigon is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Erigon is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Erigon. If not, see <http://www.gnu.org/licenses/>.
+
+package rpctest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/erigontech/erigon-lib/common"
+)
+
+func TestDiffLogs(t *testing.T) {
+	tx1 := common.HexToHash("0x01")
+	tx2 := common.HexToHash("0x02")
+
+	cases := []struct {
+		name        string
+		erigon      []Log
+		geth        []Log
+		wantNumDiff int
+	}{
+		{
+			name:        "identical",
+			erigon:      []Log{{BlockNumber: 10, TxIndex: 0, Index: 0, TxHash: tx1, Data: []byte("a")}},
+			geth:        []Log{{BlockNumber: 10, TxIndex: 0, Index: 0, TxHash: tx1, Data: []byte("a")}},
+			wantNumDiff: 0,
+		},
+		{
+			name:        "only in erigon",
+			erigon:      []Log{{BlockNumber: 10, TxIndex: 0, Index: 0, TxHash: tx1, Data: []byte("a")}},
+			geth:        nil,
+			wantNumDiff: 1,
+		},
+		{
+			name:        "only in geth",
+			erigon:      nil,
+			geth:        []Log{{BlockNumber: 10, TxIndex: 0, Index: 0, TxHash: tx1, Data: []byte("a")}},
+			wantNumDiff: 1,
+		},
+		{
+			name:        "mismatched index for same tx",
+			erigon:      []Log{{BlockNumber: 10, TxIndex: 0, Index: 0, TxHash: tx1, Data: []byte("a")}},
+			geth:        []Log{{BlockNumber: 10, TxIndex: 0, Index: 1, TxHash: tx1, Data: []byte("a")}},
+			wantNumDiff: 1,
+		},
+		{
+			name:        "mismatched tx hash for same block/txIndex",
+			erigon:      []Log{{BlockNumber: 10, TxIndex: 0, Index: 0, TxHash: tx1, Data: []byte("a")}},
+			geth:        []Log{{BlockNumber: 10, TxIndex: 0, Index: 0, TxHash: tx2, Data: []byte("a")}},
+			wantNumDiff: 1,
+		},
+		{
+			name: "different blocks don't pair up",
+			erigon: []Log{
+				{BlockNumber: 10, TxIndex: 0, Index: 0, TxHash: tx1, Data: []byte("a")},
+			},
+			geth: []Log{
+				{BlockNumber: 11, TxIndex: 0, Index: 0, TxHash: tx1, Data: []byte("a")},
+			},
+			wantNumDiff: 2,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			diffs := diffLogs(tc.erigon, tc.geth)
+			if len(diffs) != tc.wantNumDiff {
+				t.Fatalf("diffLogs() = %v, want %d diffs", diffs, tc.wantNumDiff)
+			}
+		})
+	}
+}
+
+func TestBisectRangeFunc(t *testing.T) {
+	cases := []struct {
+		name     string
+		diverges func(from, to uint64) (bool, error)
+		from, to uint64
+		maxDepth int
+		wantFrom uint64
+		wantTo   uint64
+	}{
+		{
+			name:     "narrows to a single block",
+			diverges: func(from, to uint64) (bool, error) { return from <= 7 && 7 < to, nil },
+			from:     0, to: 16, maxDepth: 10,
+			wantFrom: 7, wantTo: 8,
+		},
+		{
+			name:     "stops at maxDepth before reaching a single block",
+			diverges: func(from, to uint64) (bool, error) { return from <= 7 && 7 < to, nil },
+			from:     0, to: 16, maxDepth: 1,
+			wantFrom: 0, wantTo: 8,
+		},
+		{
+			name:     "stops when neither half reproduces alone",
+			diverges: func(from, to uint64) (bool, error) { return to-from >= 16, nil },
+			from:     0, to: 16, maxDepth: 10,
+			wantFrom: 0, wantTo: 16,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			gotFrom, gotTo, err := bisectRangeFunc(tc.diverges, tc.from, tc.to, tc.maxDepth)
+			if err != nil {
+				t.Fatalf("bisectRangeFunc() error = %v, want nil", err)
+			}
+			if gotFrom != tc.wantFrom || gotTo != tc.wantTo {
+				t.Fatalf("bisectRangeFunc() = (%d, %d), want (%d, %d)", gotFrom, gotTo, tc.wantFrom, tc.wantTo)
+			}
+		})
+	}
+}
+
+func TestBisectRangeFuncAbortsOnError(t *testing.T) {
+	wantErr := fmt.Errorf("transport error")
+	calls := 0
+	diverges := func(from, to uint64) (bool, error) {
+		calls++
+		if from == 0 && to == 8 {
+			return false, wantErr
+		}
+		return from <= 7 && 7 < to, nil
+	}
+
+	gotFrom, gotTo, err := bisectRangeFunc(diverges, 0, 16, 10)
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("bisectRangeFunc() error = %v, want %v", err, wantErr)
+	}
+	if gotFrom != 0 || gotTo != 16 {
+		t.Fatalf("bisectRangeFunc() = (%d, %d), want the original (0, 16) range on error", gotFrom, gotTo)
+	}
+	if calls != 1 {
+		t.Fatalf("diverges was called %d times, want 1 (search must stop at the first error)", calls)
+	}
+}
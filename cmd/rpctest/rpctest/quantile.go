@@ -0,0 +1,176 @@
+// Copyright 2024 The Erigon Authors
+// This file is part of Erigon.
+//
+// Erigon is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Erigon is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Erigon. If not, see <http://www.gnu.org/licenses/>.
+
+package rpctest
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// p2Quantile estimates a single quantile of a stream in O(1) memory using the P² ("Piecewise
+// Parabolic") algorithm (Jain & Chlamtac, 1985). Unlike keeping all samples, this never grows
+// with the number of observations, which matters for long-running rpctest invocations.
+type p2Quantile struct {
+	p        float64
+	n        [5]int
+	nDesired [5]float64
+	dn       [5]float64
+	heights  [5]float64
+	count    int
+}
+
+func newP2Quantile(p float64) *p2Quantile {
+	return &p2Quantile{p: p}
+}
+
+func (q *p2Quantile) observe(x float64) {
+	q.count++
+	if q.count <= 5 {
+		q.heights[q.count-1] = x
+		if q.count == 5 {
+			sort.Float64s(q.heights[:])
+			for i := 0; i < 5; i++ {
+				q.n[i] = i + 1
+			}
+			q.nDesired = [5]float64{1, 1 + 2*q.p, 1 + 4*q.p, 3 + 2*q.p, 5}
+			q.dn = [5]float64{0, q.p / 2, q.p, (1 + q.p) / 2, 1}
+		}
+		return
+	}
+
+	var k int
+	switch {
+	case x < q.heights[0]:
+		q.heights[0] = x
+		k = 0
+	case x >= q.heights[4]:
+		q.heights[4] = x
+		k = 3
+	default:
+		k = 0
+		for i := 1; i < 5; i++ {
+			if x < q.heights[i] {
+				k = i - 1
+				break
+			}
+		}
+	}
+	for i := k + 1; i < 5; i++ {
+		q.n[i]++
+	}
+	for i := range q.nDesired {
+		q.nDesired[i] += q.dn[i]
+	}
+
+	for i := 1; i < 4; i++ {
+		d := q.nDesired[i] - float64(q.n[i])
+		if (d >= 1 && q.n[i+1]-q.n[i] > 1) || (d <= -1 && q.n[i-1]-q.n[i] < -1) {
+			sign := 1
+			if d < 0 {
+				sign = -1
+			}
+			hp := q.parabolic(i, sign)
+			if q.heights[i-1] < hp && hp < q.heights[i+1] {
+				q.heights[i] = hp
+			} else {
+				q.heights[i] = q.linear(i, sign)
+			}
+			q.n[i] += sign
+		}
+	}
+}
+
+func (q *p2Quantile) parabolic(i, d int) float64 {
+	fd := float64(d)
+	return q.heights[i] + fd/float64(q.n[i+1]-q.n[i-1])*
+		((float64(q.n[i]-q.n[i-1])+fd)*(q.heights[i+1]-q.heights[i])/float64(q.n[i+1]-q.n[i])+
+			(float64(q.n[i+1]-q.n[i])-fd)*(q.heights[i]-q.heights[i-1])/float64(q.n[i]-q.n[i-1]))
+}
+
+func (q *p2Quantile) linear(i, d int) float64 {
+	fd := float64(d)
+	return q.heights[i] + fd*(q.heights[i+d]-q.heights[i])/float64(q.n[i+d]-q.n[i])
+}
+
+// value returns the current quantile estimate. Until 5 samples have been observed, it
+// falls back to an exact computation over the samples seen so far.
+func (q *p2Quantile) value() float64 {
+	if q.count == 0 {
+		return 0
+	}
+	if q.count < 5 {
+		sorted := append([]float64(nil), q.heights[:q.count]...)
+		sort.Float64s(sorted)
+		idx := int(q.p * float64(len(sorted)-1))
+		return sorted[idx]
+	}
+	return q.heights[2]
+}
+
+// latencyHistogram tracks streaming p50/p95/p99 estimates for a single RPC method.
+type latencyHistogram struct {
+	p50, p95, p99 *p2Quantile
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{p50: newP2Quantile(0.50), p95: newP2Quantile(0.95), p99: newP2Quantile(0.99)}
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	ms := float64(d.Microseconds()) / 1000
+	h.p50.observe(ms)
+	h.p95.observe(ms)
+	h.p99.observe(ms)
+}
+
+// methodHistograms keeps one latencyHistogram per RPC method observed during a run.
+type methodHistograms struct {
+	mu       sync.Mutex
+	byMethod map[string]*latencyHistogram
+}
+
+func newMethodHistograms() *methodHistograms {
+	return &methodHistograms{byMethod: make(map[string]*latencyHistogram)}
+}
+
+func (m *methodHistograms) observe(method string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.byMethod[method]
+	if !ok {
+		h = newLatencyHistogram()
+		m.byMethod[method] = h
+	}
+	h.observe(d)
+}
+
+func (m *methodHistograms) print(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	methods := make([]string, 0, len(m.byMethod))
+	for method := range m.byMethod {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		h := m.byMethod[method]
+		fmt.Fprintf(w, "%s: p50=%.1fms p95=%.1fms p99=%.1fms\n", method, h.p50.value(), h.p95.value(), h.p99.value())
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 The Erigon Authors
+// This file is part of Erigon.
+//
+// Erigon is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Erigon is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Erigon. If not, see <http://www.gnu.org/licenses/>.
+
+package rpctest
+
+import (
+	"math"
+	"testing"
+)
+
+func TestP2QuantileConvergesOnUniformStream(t *testing.T) {
+	cases := []struct {
+		name         string
+		p            float64
+		want         float64
+		toleranceAbs float64
+	}{
+		{name: "p50", p: 0.50, want: 500.5, toleranceAbs: 25},
+		{name: "p95", p: 0.95, want: 950, toleranceAbs: 30},
+		{name: "p99", p: 0.99, want: 990, toleranceAbs: 30},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := newP2Quantile(tc.p)
+			for i := 1; i <= 1000; i++ {
+				q.observe(float64(i))
+			}
+			got := q.value()
+			if math.Abs(got-tc.want) > tc.toleranceAbs {
+				t.Fatalf("value() = %v, want within %v of %v", got, tc.toleranceAbs, tc.want)
+			}
+		})
+	}
+}
+
+func TestP2QuantileFewSamplesIsExact(t *testing.T) {
+	q := newP2Quantile(0.5)
+	for _, x := range []float64{3, 1, 2} {
+		q.observe(x)
+	}
+	if got := q.value(); got != 2 {
+		t.Fatalf("value() = %v, want 2 (exact median of {1,2,3})", got)
+	}
+}
+
+func TestP2QuantileNoSamples(t *testing.T) {
+	q := newP2Quantile(0.5)
+	if got := q.value(); got != 0 {
+		t.Fatalf("value() on an empty stream = %v, want 0", got)
+	}
+}
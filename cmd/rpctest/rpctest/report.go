@@ -0,0 +1,223 @@
+// Copyright 2024 The Erigon Authors
+// This file is part of Erigon.
+//
+// Erigon is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Erigon is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Erigon. If not, see <http://www.gnu.org/licenses/>.
+
+package rpctest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RequestReport describes the outcome of a single RPC call, for consumption by a Reporter.
+type RequestReport struct {
+	Method      string
+	ParamsHash  string // hex of the first 8 bytes of the request body's sha256, so CI diffs don't leak full params
+	Took        time.Duration
+	Status      string // "ok", "error", "mismatch"
+	DiffSummary string // non-empty when Status == "mismatch"
+}
+
+// Reporter receives one RequestReport per RPC call made by BenchEthGetLogs and
+// EthGetLogsInvariants, and turns the stream into some artifact a human or CI system can
+// consume. Close must be called once the run is done to flush/finalize that artifact.
+type Reporter interface {
+	Record(RequestReport)
+	Close() error
+}
+
+// NewReporter builds a Reporter from a --report flag value of the form "kind:path", e.g.
+// "junit:/tmp/out.xml" or "json:/tmp/out.jsonl". "stdout" (the default) needs no path and
+// prints a one-line summary for each request that did not succeed; successful requests only
+// feed the per-method latency percentiles printed on Close.
+func NewReporter(spec string) (Reporter, error) {
+	if spec == "" || spec == "stdout" {
+		return newStdoutReporter(), nil
+	}
+	kind, path, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid --report spec %q, want kind:path (e.g. junit:/tmp/out.xml)", spec)
+	}
+	switch kind {
+	case "json":
+		return newJSONReporter(path)
+	case "junit":
+		return newJUnitReporter(path)
+	default:
+		return nil, fmt.Errorf("unknown report kind %q, want one of: json, junit, stdout", kind)
+	}
+}
+
+func paramsHash(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:8])
+}
+
+// requestReportFor builds a RequestReport from the outcome of a single requestAndCompare
+// call: err == nil is "ok", anything else is a genuine Erigon/Geth data disagreement, so
+// it's reported as a "mismatch" with err's message as the diff summary.
+func requestReportFor(method, requestBody string, took time.Duration, err error) RequestReport {
+	rr := RequestReport{Method: method, ParamsHash: paramsHash(requestBody), Took: took, Status: "ok"}
+	if err != nil {
+		rr.Status = "mismatch"
+		rr.DiffSummary = err.Error()
+	}
+	return rr
+}
+
+// requestReportForResult builds a RequestReport from the outcome of a single direct RPC
+// call (no comparison involved, e.g. a RequestGenerator.Erigon/BatchErigon round trip):
+// res.Err == nil is "ok", anything else is a transport/decode failure, reported as "error"
+// rather than "mismatch" so CI output doesn't mislabel a dead endpoint as a data divergence.
+func requestReportForResult(method, requestBody string, res CallResult) RequestReport {
+	rr := RequestReport{Method: method, ParamsHash: paramsHash(requestBody), Took: res.Took, Status: "ok"}
+	if res.Err != nil {
+		rr.Status = "error"
+		rr.DiffSummary = res.Err.Error()
+	}
+	return rr
+}
+
+// stdoutReporter prints a line for each request whose status is not "ok" and keeps
+// streaming p50/p95/p99 latency histograms per method, printed on Close.
+type stdoutReporter struct {
+	hist *methodHistograms
+}
+
+func newStdoutReporter() *stdoutReporter {
+	return &stdoutReporter{hist: newMethodHistograms()}
+}
+
+func (r *stdoutReporter) Record(rr RequestReport) {
+	r.hist.observe(rr.Method, rr.Took)
+	if rr.Status == "ok" {
+		return
+	}
+	fmt.Printf("[%s] %s took=%s: %s\n", rr.Status, rr.Method, rr.Took, rr.DiffSummary)
+}
+
+func (r *stdoutReporter) Close() error {
+	r.hist.print(os.Stdout)
+	return nil
+}
+
+// jsonReporter writes one JSON object per request (JSON-lines), so each line is
+// independently parseable by a CI dashboard without buffering the whole file.
+type jsonReporter struct {
+	mu   sync.Mutex
+	f    *os.File
+	enc  *json.Encoder
+	hist *methodHistograms
+}
+
+func newJSONReporter(path string) (*jsonReporter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("report: cannot create %s: %w", path, err)
+	}
+	return &jsonReporter{f: f, enc: json.NewEncoder(f), hist: newMethodHistograms()}, nil
+}
+
+func (r *jsonReporter) Record(rr RequestReport) {
+	r.hist.observe(rr.Method, rr.Took)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_ = r.enc.Encode(struct {
+		Method      string `json:"method"`
+		ParamsHash  string `json:"params_hash"`
+		TookMs      int64  `json:"took_ms"`
+		Status      string `json:"status"`
+		DiffSummary string `json:"diff_summary,omitempty"`
+	}{rr.Method, rr.ParamsHash, rr.Took.Milliseconds(), rr.Status, rr.DiffSummary})
+}
+
+func (r *jsonReporter) Close() error {
+	r.hist.print(os.Stdout)
+	return r.f.Close()
+}
+
+// junitReporter accumulates one testcase per request and writes a single JUnit XML
+// document on Close, so results render natively in Jenkins/GitHub Actions.
+type junitReporter struct {
+	path  string
+	mu    sync.Mutex
+	hist  *methodHistograms
+	suite junitTestSuite
+}
+
+type junitTestSuite struct {
+	XMLName  xml.Name        `xml:"testsuite"`
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	TimeSec   float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func newJUnitReporter(path string) (*junitReporter, error) {
+	return &junitReporter{path: path, hist: newMethodHistograms(), suite: junitTestSuite{Name: "rpctest"}}, nil
+}
+
+func (r *junitReporter) Record(rr RequestReport) {
+	r.hist.observe(rr.Method, rr.Took)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.suite.Tests++
+	tc := junitTestCase{
+		Name:      fmt.Sprintf("%s#%s", rr.Method, rr.ParamsHash),
+		ClassName: rr.Method,
+		TimeSec:   rr.Took.Seconds(),
+	}
+	if rr.Status != "ok" {
+		r.suite.Failures++
+		tc.Failure = &junitFailure{Message: rr.Status, Text: rr.DiffSummary}
+	}
+	r.suite.Cases = append(r.suite.Cases, tc)
+}
+
+func (r *junitReporter) Close() error {
+	r.hist.print(os.Stdout)
+
+	f, err := os.Create(r.path)
+	if err != nil {
+		return fmt.Errorf("report: cannot create %s: %w", r.path, err)
+	}
+	defer f.Close()
+
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "  ")
+	if _, err := f.WriteString(xml.Header); err != nil {
+		return err
+	}
+	return enc.Encode(r.suite)
+}
@@ -0,0 +1,197 @@
+// Copyright 2024 The Erigon Authors
+// This file is part of Erigon.
+//
+// Erigon is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Erigon is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Erigon. If not, see <http://www.gnu.org/licenses/>.
+
+package rpctest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+)
+
+// logsSubscriptionParams is the "logs" filter object accepted by eth_subscribe, matching
+// the address/topics shape eth_getLogs itself accepts. Unlike eth_getLogs, eth_subscribe
+// does not honor fromBlock/toBlock - it only ever streams logs for blocks mined after the
+// subscription is created - so this intentionally carries no block-range fields.
+type logsSubscriptionParams struct {
+	Address string   `json:"address,omitempty"`
+	Topics  []string `json:"topics,omitempty"`
+}
+
+type subscribeRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type subscriptionNotification struct {
+	Method string `json:"method"`
+	Params struct {
+		Subscription string `json:"subscription"`
+		Result       Log    `json:"result"`
+	} `json:"params"`
+}
+
+// logIdentity identifies a single log globally: Log.Index is only unique within the block
+// it belongs to, so the block number and transaction hash are needed alongside it to union
+// logs coming from two different sources (a getLogs replay and a live subscription) without
+// them colliding on index alone.
+type logIdentity struct {
+	blockNumber uint64
+	txHash      string
+	index       uint
+}
+
+func identityOf(l Log) logIdentity {
+	return logIdentity{blockNumber: uint64(l.BlockNumber), txHash: l.TxHash.Hex(), index: uint(l.Index)}
+}
+
+// EthGetLogsSubscriptionInvariant opens an eth_subscribe("logs") websocket against Erigon,
+// then checks that the union of logs it observes over the subscription and the logs
+// replayed via a plain eth_getLogs call for already-mined blocks equals the logs returned by
+// an unfiltered eth_getLogs call over the full [blockFrom, blockTo) - catching regressions
+// where the subscription pipeline (used by wallets/indexers listening live) diverges from
+// the query pipeline. eth_subscribe only streams logs for blocks mined after it is opened,
+// so anything at or below the current tip has to be backfilled from eth_getLogs instead of
+// waited for on the socket. The subscription is opened before the tip is read (and before
+// the replay call) so that any block mined in the gap between them is guaranteed to land on
+// one side or the other: it is either already reflected in the tip the replay bounds itself
+// by, or it was mined after the subscription opened and therefore streams over the socket -
+// there is no window where a block is too new for replay but too old for the subscription.
+func EthGetLogsSubscriptionInvariant(ctx context.Context, erigonURL, erigonWSURL string, blockFrom, blockTo uint64) error {
+	reqGen := &RequestGenerator{}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, erigonWSURL, nil)
+	if err != nil {
+		return fmt.Errorf("EthGetLogsSubscriptionInvariant: could not dial websocket %s: %w", erigonWSURL, err)
+	}
+	defer conn.Close()
+
+	subReq := subscribeRequest{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  "eth_subscribe",
+		Params:  []interface{}{"logs", logsSubscriptionParams{}},
+	}
+	if err := conn.WriteJSON(subReq); err != nil {
+		return fmt.Errorf("EthGetLogsSubscriptionInvariant: could not send eth_subscribe: %w", err)
+	}
+
+	var subResp struct {
+		Result string `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := conn.ReadJSON(&subResp); err != nil {
+		return fmt.Errorf("EthGetLogsSubscriptionInvariant: could not read eth_subscribe response: %w", err)
+	}
+	if subResp.Error != nil {
+		return fmt.Errorf("EthGetLogsSubscriptionInvariant: eth_subscribe failed: %d %s", subResp.Error.Code, subResp.Error.Message)
+	}
+
+	var tip EthBlockNumber
+	res := reqGen.Erigon("eth_blockNumber", reqGen.blockNumber(), &tip)
+	if res.Err != nil {
+		return fmt.Errorf("EthGetLogsSubscriptionInvariant: could not get block number: %v\n", res.Err)
+	}
+	if tip.Error != nil {
+		return fmt.Errorf("EthGetLogsSubscriptionInvariant: error getting block number: %d %s\n", tip.Error.Code, tip.Error.Message)
+	}
+
+	lastBlock := blockTo - 1
+	seen := map[logIdentity]struct{}{}
+
+	replayTo := min(lastBlock, tip.Number)
+	if replayTo >= blockFrom {
+		var replay EthGetLogs
+		res := reqGen.Erigon("eth_getLogs", reqGen.getLogsNoFilters(blockFrom, replayTo), &replay)
+		if res.Err != nil {
+			return fmt.Errorf("EthGetLogsSubscriptionInvariant: could not replay logs %d-%d: %v\n", blockFrom, replayTo, res.Err)
+		}
+		if replay.Error != nil {
+			return fmt.Errorf("EthGetLogsSubscriptionInvariant: error replaying logs %d-%d: %d %s\n", blockFrom, replayTo, replay.Error.Code, replay.Error.Message)
+		}
+		for _, l := range replay.Result {
+			seen[identityOf(l)] = struct{}{}
+		}
+	}
+
+	if replayTo < lastBlock {
+		// Keep reading until a log for a block past lastBlock arrives, meaning every log up to
+		// lastBlock has been streamed, or the caller's context ends it. Logs outside
+		// [blockFrom, lastBlock] are not added to the union.
+		// conn.ReadMessage has no context-aware variant and blocks until a message (or a
+		// conn error) arrives, so a canceled/expired ctx wouldn't otherwise interrupt a read
+		// that's waiting out a quiet chain. This watcher goroutine closes conn as soon as ctx
+		// is done, which unblocks the pending ReadMessage with an error; stopCh stops the
+		// watcher once the loop exits on its own so it doesn't close a conn the caller still
+		// owns after this function returns successfully.
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+		go func() {
+			select {
+			case <-ctx.Done():
+				conn.Close()
+			case <-stopCh:
+			}
+		}()
+
+		for {
+			_, raw, err := conn.ReadMessage()
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return fmt.Errorf("EthGetLogsSubscriptionInvariant: websocket read failed after %d logs: %w", len(seen), err)
+			}
+			var notif subscriptionNotification
+			if err := json.Unmarshal(raw, &notif); err != nil {
+				return fmt.Errorf("EthGetLogsSubscriptionInvariant: could not decode notification %s: %w", raw, err)
+			}
+			l := notif.Params.Result
+			if uint64(l.BlockNumber) > lastBlock {
+				break
+			}
+			if uint64(l.BlockNumber) >= blockFrom {
+				seen[identityOf(l)] = struct{}{}
+			}
+		}
+	}
+
+	var want EthGetLogs
+	res = reqGen.Erigon("eth_getLogs", reqGen.getLogsNoFilters(blockFrom, lastBlock), &want)
+	if res.Err != nil {
+		return fmt.Errorf("EthGetLogsSubscriptionInvariant: could not get reference logs: %v\n", res.Err)
+	}
+	if want.Error != nil {
+		return fmt.Errorf("EthGetLogsSubscriptionInvariant: error getting reference logs: %d %s\n", want.Error.Code, want.Error.Message)
+	}
+
+	if len(seen) != len(want.Result) {
+		return fmt.Errorf("EthGetLogsSubscriptionInvariant: subscription+replay union delivered %d logs, eth_getLogs returned %d for blocks %d-%d", len(seen), len(want.Result), blockFrom, blockTo)
+	}
+	for _, l := range want.Result {
+		if _, ok := seen[identityOf(l)]; !ok {
+			return fmt.Errorf("EthGetLogsSubscriptionInvariant: log block=%d tx=%s index=%d present in eth_getLogs but missing from subscription+replay union, blocks %d-%d", l.BlockNumber, l.TxHash.Hex(), l.Index, blockFrom, blockTo)
+		}
+	}
+	return nil
+}